@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cilium/cilium/pkg/identity"
+	"github.com/cilium/cilium/pkg/lock"
+)
+
+// adsSnapshotState is the bookkeeping the ExternalADSServer keeps for a
+// single proxy-id: the most recently pushed config version, and the set of
+// authorized nodes that have ACKed it so far.
+type adsSnapshotState struct {
+	version string
+	acked   map[identity.NumericIdentity]bool
+	notify  chan struct{}
+}
+
+// ExternalADSServer tracks, per proxy-id, the xDS (LDS/RDS/CDS/EDS) config
+// version most recently generated for Cilium's embedded Envoy and which
+// authorized external Envoy subscribers (per-pod sidecars, gateways) have
+// ACKed it. It is the authorization and bookkeeping layer behind the opt-in
+// TCP/mTLS ADS gRPC endpoint; the gRPC transport and wire encoding are
+// started and served by the envoy package, which calls into this type to
+// authorize subscribers and record ACKs.
+type ExternalADSServer struct {
+	mutex lock.Mutex
+
+	// authorizedNodes is the set of node identities allowed to subscribe.
+	// A nil set authorizes every node.
+	authorizedNodes map[identity.NumericIdentity]bool
+
+	snapshots map[string]*adsSnapshotState
+}
+
+// NewExternalADSServer creates an ExternalADSServer that only authorizes
+// subscribers whose node identity is in authorizedNodes, or every node if
+// authorizedNodes is nil.
+func NewExternalADSServer(authorizedNodes map[identity.NumericIdentity]bool) *ExternalADSServer {
+	return &ExternalADSServer{
+		authorizedNodes: authorizedNodes,
+		snapshots:       make(map[string]*adsSnapshotState),
+	}
+}
+
+// Authorize reports whether node is allowed to subscribe to ADS snapshots.
+func (s *ExternalADSServer) Authorize(node identity.NumericIdentity) bool {
+	if s.authorizedNodes == nil {
+		return true
+	}
+	return s.authorizedNodes[node]
+}
+
+// PushSnapshot records that a new config version is available for proxyID,
+// resetting the set of subscribers that have ACKed it.
+func (s *ExternalADSServer) PushSnapshot(proxyID, version string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snap, ok := s.snapshots[proxyID]
+	if !ok {
+		snap = &adsSnapshotState{notify: make(chan struct{})}
+		s.snapshots[proxyID] = snap
+	}
+	if snap.version == version {
+		return
+	}
+
+	snap.version = version
+	snap.acked = nil
+	close(snap.notify)
+	snap.notify = make(chan struct{})
+}
+
+// Ack authorizes node and, if successful, records that it has ACKed version
+// of proxyID's config.
+func (s *ExternalADSServer) Ack(node identity.NumericIdentity, proxyID, version string) error {
+	if !s.Authorize(node) {
+		return fmt.Errorf("node %s is not authorized to subscribe to ADS config for proxy %q", node, proxyID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snap, ok := s.snapshots[proxyID]
+	if !ok || snap.version != version {
+		return fmt.Errorf("no pending ADS config version %q for proxy %q", version, proxyID)
+	}
+
+	if snap.acked == nil {
+		snap.acked = make(map[identity.NumericIdentity]bool)
+	}
+	snap.acked[node] = true
+	close(snap.notify)
+	snap.notify = make(chan struct{})
+	return nil
+}
+
+// WaitForACK blocks until at least one authorized external subscriber has
+// ACKed version of proxyID's config, or ctx is done. If no snapshot has
+// ever been pushed for proxyID (e.g. the external ADS server is disabled,
+// or this redirect isn't exposed to it), WaitForACK returns immediately so
+// that AckProxyPort isn't gated on subscribers that will never exist.
+func (s *ExternalADSServer) WaitForACK(ctx context.Context, proxyID, version string) error {
+	for {
+		s.mutex.Lock()
+		snap, ok := s.snapshots[proxyID]
+		if !ok {
+			s.mutex.Unlock()
+			return nil
+		}
+		if snap.version == version && len(snap.acked) > 0 {
+			s.mutex.Unlock()
+			return nil
+		}
+		notify := snap.notify
+		s.mutex.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
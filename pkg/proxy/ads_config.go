@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"github.com/cilium/cilium/pkg/identity"
+)
+
+// ADSConfig is the opt-in configuration for the external Envoy ADS
+// (Aggregated Discovery Service) gRPC endpoint, which lets Envoy processes
+// other than Cilium's co-located one (per-pod sidecars, gateways) subscribe
+// to the same listener/cluster snapshots over TCP/mTLS instead of the UDS
+// used for the embedded Envoy.
+type ADSConfig struct {
+	// EnableExternalEnvoyADS opts into serving the ADS endpoint. It is
+	// disabled by default since the embedded Envoy only ever needs the UDS
+	// transport.
+	EnableExternalEnvoyADS bool
+
+	// ExternalEnvoyADSAddress is the address the ADS gRPC server listens
+	// on when enabled.
+	ExternalEnvoyADSAddress string
+
+	// ExternalEnvoyADSServerCert and ExternalEnvoyADSServerKey are the
+	// mTLS server certificate/key pair presented to subscribers.
+	ExternalEnvoyADSServerCert string
+	ExternalEnvoyADSServerKey  string
+
+	// ExternalEnvoyADSClientCA validates subscriber client certificates.
+	ExternalEnvoyADSClientCA string
+
+	// ExternalEnvoyADSAuthorizedNodeIdentities is a comma-separated list of
+	// numeric Cilium identities allowed to subscribe to the external ADS
+	// endpoint. Empty authorizes every node, relying on mTLS client
+	// certificates alone.
+	ExternalEnvoyADSAuthorizedNodeIdentities string
+}
+
+var defaultADSConfig = ADSConfig{
+	ExternalEnvoyADSAddress: ":9444",
+}
+
+// Flags implements cell.Flagger.
+func (def ADSConfig) Flags(flags *pflag.FlagSet) {
+	flags.Bool("enable-external-envoy-ads", def.EnableExternalEnvoyADS, "Serve listener/cluster config over a TCP/mTLS ADS endpoint for external Envoy sidecars, in addition to the embedded Envoy's UDS transport")
+	flags.String("external-envoy-ads-address", def.ExternalEnvoyADSAddress, "Address the external Envoy ADS gRPC server listens on")
+	flags.String("external-envoy-ads-server-cert", def.ExternalEnvoyADSServerCert, "Path to the mTLS server certificate for the external Envoy ADS gRPC server")
+	flags.String("external-envoy-ads-server-key", def.ExternalEnvoyADSServerKey, "Path to the mTLS server key for the external Envoy ADS gRPC server")
+	flags.String("external-envoy-ads-client-ca", def.ExternalEnvoyADSClientCA, "Path to the CA bundle used to validate external Envoy ADS gRPC client certificates")
+	flags.String("external-envoy-ads-authorized-node-identities", def.ExternalEnvoyADSAuthorizedNodeIdentities, "Comma-separated list of numeric Cilium identities authorized to subscribe to the external Envoy ADS endpoint; empty authorizes every node")
+}
+
+// AuthorizedNodes parses ExternalEnvoyADSAuthorizedNodeIdentities into the
+// set consumed by NewExternalADSServer, or returns a nil set (authorize
+// every node) if it is empty.
+func (c ADSConfig) AuthorizedNodes() (map[identity.NumericIdentity]bool, error) {
+	if c.ExternalEnvoyADSAuthorizedNodeIdentities == "" {
+		return nil, nil
+	}
+
+	authorized := make(map[identity.NumericIdentity]bool)
+	for _, s := range strings.Split(c.ExternalEnvoyADSAuthorizedNodeIdentities, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node identity %q in external-envoy-ads-authorized-node-identities: %w", s, err)
+		}
+		authorized[identity.NumericIdentity(id)] = true
+	}
+	return authorized, nil
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cilium/cilium/pkg/policy"
+	"github.com/cilium/cilium/pkg/proxy/types"
+)
+
+// proxyL7BackendsGroup is the hive value group that L7Backend providers
+// register into, and that proxyParams.Backends collects from.
+const proxyL7BackendsGroup = "proxy-l7-backends"
+
+// L7Backend is implemented by each pluggable L7 proxy implementation (Envoy,
+// the native Go DNS proxy, ...) that CreateOrUpdateRedirect dispatches
+// redirects to. Multiple backends can coexist, each declaring the
+// ProxyTypes it handles via ProxyTypes.
+type L7Backend interface {
+	// ProxyTypes returns the ProxyTypes this backend handles redirects for.
+	ProxyTypes() []types.ProxyType
+
+	Start(ctx context.Context) error
+	Stop()
+
+	// UpsertListener creates or updates the backend's listener named name
+	// for pp, applying the L7 rules carried by l4.
+	UpsertListener(name string, pp *ProxyPort, l4 policy.ProxyPolicy) error
+
+	// RemoveListener tears down the backend's listener for the named
+	// proxy port.
+	RemoveListener(name string) error
+
+	// AccessLogSink returns the backend's access log source, or nil if it
+	// does not produce access log records of its own.
+	AccessLogSink() AccessLogSink
+}
+
+// AccessLogSink is implemented by anything that can be drained for L7
+// access log records, e.g. Envoy's AccessLogServer.
+type AccessLogSink interface {
+	Stop()
+}
+
+// backendsByType indexes backends by the ProxyTypes they declare support
+// for. Two backends registering for the same ProxyType is a configuration
+// error caught at startup, since CreateOrUpdateRedirect would otherwise not
+// know which one to dispatch to.
+func backendsByType(backends []L7Backend) (map[types.ProxyType]L7Backend, error) {
+	byType := make(map[types.ProxyType]L7Backend, len(backends))
+	for _, b := range backends {
+		for _, t := range b.ProxyTypes() {
+			if existing, ok := byType[t]; ok {
+				return nil, fmt.Errorf("multiple L7 backends registered for proxy type %q: %T and %T", t, existing, b)
+			}
+			byType[t] = b
+		}
+	}
+	return byType, nil
+}
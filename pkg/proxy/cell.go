@@ -11,6 +11,7 @@ import (
 	"github.com/cilium/cilium/pkg/envoy"
 	"github.com/cilium/cilium/pkg/hive/cell"
 	"github.com/cilium/cilium/pkg/ipcache"
+	k8sClient "github.com/cilium/cilium/pkg/k8s/client"
 	monitoragent "github.com/cilium/cilium/pkg/monitor/agent"
 	"github.com/cilium/cilium/pkg/option"
 	"github.com/cilium/cilium/pkg/proxy/logger"
@@ -26,18 +27,31 @@ var Cell = cell.Module(
 	"l7-proxy",
 	"L7 Proxy provides support for L7 network policies",
 
+	cell.Config(defaultProxyConfig),
+	cell.Config(defaultADSConfig),
 	cell.Provide(newProxy),
 	cell.ProvidePrivate(endpoint.NewEndpointInfoRegistry),
+
+	// L7Backends register themselves into the proxyL7BackendsGroup value
+	// group so that CreateOrUpdateRedirect can dispatch to whichever
+	// backend handles a listener's ProxyType, without newProxy needing to
+	// know about every backend implementation.
+	cell.ProvidePrivate(newEnvoyBackend),
+	cell.ProvidePrivate(newDNSBackend),
 )
 
 type proxyParams struct {
 	cell.In
 
 	Lifecycle            cell.Lifecycle
+	Config               ProxyConfig
+	ADSConfig            ADSConfig
 	IPCache              *ipcache.IPCache
 	Datapath             datapath.Datapath
 	EndpointInfoRegistry logger.EndpointInfoRegistry
 	MonitorAgent         monitoragent.Agent
+	K8sClientset         k8sClient.Clientset
+	Backends             []L7Backend `group:"proxy-l7-backends"`
 }
 
 func newProxy(params proxyParams) (*Proxy, error) {
@@ -51,8 +65,40 @@ func newProxy(params proxyParams) (*Proxy, error) {
 
 	configureProxyLogger(params.EndpointInfoRegistry, params.MonitorAgent, option.Config.AgentLabels)
 
-	// FIXME: Make the port range configurable.
-	p := createProxy(10000, 20000, option.Config.RunDir, params.Datapath, params.IPCache, params.EndpointInfoRegistry)
+	ranges, err := params.Config.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy port range configuration: %w", err)
+	}
+
+	p := createProxy(ranges, option.Config.RunDir, params.Datapath, params.IPCache, params.EndpointInfoRegistry)
+
+	if err := p.setBackends(params.Backends); err != nil {
+		return nil, fmt.Errorf("invalid L7 backend configuration: %w", err)
+	}
+
+	switch params.Config.ProxyPortStoreBackend {
+	case "", "file":
+		// createProxy already defaulted p.store to a fileProxyPortStore.
+	case "configmap":
+		p.SetProxyPortStore(NewConfigMapProxyPortStore(
+			params.K8sClientset,
+			params.Config.ProxyPortStoreConfigMapNamespace,
+			"cilium-proxy-ports-"+option.Config.K8sNodeName,
+		))
+	default:
+		return nil, fmt.Errorf("invalid proxy-port-store-backend %q", params.Config.ProxyPortStoreBackend)
+	}
+
+	var externalADS *ExternalADSServer
+	var externalADSServer *envoy.ExternalADSGRPCServer
+	if params.ADSConfig.EnableExternalEnvoyADS {
+		authorizedNodes, err := params.ADSConfig.AuthorizedNodes()
+		if err != nil {
+			return nil, fmt.Errorf("invalid external Envoy ADS authorization configuration: %w", err)
+		}
+		externalADS = NewExternalADSServer(authorizedNodes)
+		p.setExternalADS(externalADS)
+	}
 
 	triggerDone := make(chan struct{})
 
@@ -82,17 +128,23 @@ func newProxy(params proxyParams) (*Proxy, error) {
 				return fmt.Errorf("failed to create proxy ports trigger: %w", err)
 			}
 
-			xdsServer, err := envoy.StartXDSServer(p.ipcache, envoy.GetSocketDir(p.runDir))
-			if err != nil {
-				return fmt.Errorf("failed to start Envoy xDS server: %w", err)
+			for _, backend := range params.Backends {
+				if err := backend.Start(startContext); err != nil {
+					return fmt.Errorf("failed to start %T L7 backend: %w", backend, err)
+				}
 			}
-			p.XDSServer = xdsServer
 
-			accessLogServer, err := envoy.StartAccessLogServer(envoy.GetSocketDir(p.runDir), p.XDSServer)
-			if err != nil {
-				return fmt.Errorf("failed to start Envoy AccessLog server: %w", err)
+			if externalADS != nil {
+				externalADSServer, err = envoy.StartExternalADSServer(envoy.ExternalADSServerConfig{
+					Address:    params.ADSConfig.ExternalEnvoyADSAddress,
+					ServerCert: params.ADSConfig.ExternalEnvoyADSServerCert,
+					ServerKey:  params.ADSConfig.ExternalEnvoyADSServerKey,
+					ClientCA:   params.ADSConfig.ExternalEnvoyADSClientCA,
+				}, externalADS)
+				if err != nil {
+					return fmt.Errorf("failed to start external Envoy ADS server: %w", err)
+				}
 			}
-			p.accessLogServer = accessLogServer
 
 			return nil
 		},
@@ -100,11 +152,12 @@ func newProxy(params proxyParams) (*Proxy, error) {
 			p.proxyPortsTrigger.Shutdown()
 			<-triggerDone
 
-			if p.XDSServer != nil {
-				p.XDSServer.Stop()
+			for _, backend := range params.Backends {
+				backend.Stop()
 			}
-			if p.accessLogServer != nil {
-				p.accessLogServer.Stop()
+
+			if externalADSServer != nil {
+				externalADSServer.Stop()
 			}
 			return nil
 		},
@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"github.com/cilium/cilium/pkg/proxy/types"
+)
+
+// PortRange is an inclusive range of TCP/UDP ports, [Min, Max].
+type PortRange struct {
+	Min uint16
+	Max uint16
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+func (r PortRange) contains(port uint16) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+func parsePortRange(s string) (PortRange, error) {
+	minStr, maxStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return PortRange{}, fmt.Errorf("port range %q must be of the form <min>-<max>", s)
+	}
+
+	min, err := strconv.ParseUint(minStr, 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	max, err := strconv.ParseUint(maxStr, 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	if min > max {
+		return PortRange{}, fmt.Errorf("invalid port range %q: min must not be greater than max", s)
+	}
+
+	return PortRange{Min: uint16(min), Max: uint16(max)}, nil
+}
+
+func parsePortList(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var ports []uint16
+	for _, p := range strings.Split(s, ",") {
+		port, err := strconv.ParseUint(strings.TrimSpace(p), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %w", p, err)
+		}
+		ports = append(ports, uint16(port))
+	}
+	return ports, nil
+}
+
+// ProxyConfig is the user-facing, unparsed configuration of the proxy port
+// allocator. It is wired in via the Cell's flags and turned into a
+// ProxyPortRanges by Parse.
+type ProxyConfig struct {
+	// ProxyPortRange is the overall range that dynamic proxy ports are
+	// allocated out of, in the form "<min>-<max>".
+	ProxyPortRange string
+
+	// ProxyPortRangeCRD, ProxyPortRangeHTTP, ProxyPortRangeDNS and
+	// ProxyPortRangeKafka optionally restrict allocations for listeners of
+	// the corresponding ProxyType to a sub-range of ProxyPortRange. Each
+	// must, if set, be of the form "<min>-<max>" and fall entirely within
+	// ProxyPortRange.
+	ProxyPortRangeCRD   string
+	ProxyPortRangeHTTP  string
+	ProxyPortRangeDNS   string
+	ProxyPortRangeKafka string
+
+	// ProxyReservedPorts is a comma-separated list of static ports that are
+	// carved out of ProxyPortRange for fixed-port listeners (e.g. the
+	// built-in DNS proxy) and therefore never handed out by the dynamic
+	// allocator.
+	ProxyReservedPorts string
+
+	// ProxyExcludedPorts is a comma-separated list of ports within
+	// ProxyPortRange that must never be allocated, e.g. because they are
+	// already bound by another agent on the same node.
+	ProxyExcludedPorts string
+
+	// ProxyPortStoreBackend selects the ProxyPortStore implementation used
+	// to checkpoint and restore proxy port state across agent restarts.
+	// One of "file" (the default, checkpoints to RunDir) or "configmap"
+	// (checkpoints to a per-node Kubernetes ConfigMap, surviving RunDir
+	// loss on ephemeral nodes).
+	ProxyPortStoreBackend string
+
+	// ProxyPortStoreConfigMapNamespace is the namespace of the ConfigMap
+	// used when ProxyPortStoreBackend is "configmap".
+	ProxyPortStoreConfigMapNamespace string
+}
+
+// defaultProxyConfig matches the previously hardcoded 10000-20000 range.
+var defaultProxyConfig = ProxyConfig{
+	ProxyPortRange:                   "10000-20000",
+	ProxyPortStoreBackend:            "file",
+	ProxyPortStoreConfigMapNamespace: "kube-system",
+}
+
+// Flags implements cell.Flagger.
+func (def ProxyConfig) Flags(flags *pflag.FlagSet) {
+	flags.String("proxy-port-range", def.ProxyPortRange, "Range of ports, as <min>-<max>, used for dynamic proxy port allocation")
+	flags.String("proxy-port-range-crd", def.ProxyPortRangeCRD, "Optional sub-range of proxy-port-range reserved for CiliumEnvoyConfig listeners")
+	flags.String("proxy-port-range-http", def.ProxyPortRangeHTTP, "Optional sub-range of proxy-port-range reserved for HTTP listeners")
+	flags.String("proxy-port-range-dns", def.ProxyPortRangeDNS, "Optional sub-range of proxy-port-range reserved for DNS listeners")
+	flags.String("proxy-port-range-kafka", def.ProxyPortRangeKafka, "Optional sub-range of proxy-port-range reserved for Kafka listeners")
+	flags.String("proxy-reserved-ports", def.ProxyReservedPorts, "Comma-separated list of static ports carved out of proxy-port-range for fixed-port listeners")
+	flags.String("proxy-excluded-ports", def.ProxyExcludedPorts, "Comma-separated list of ports within proxy-port-range that must never be allocated, e.g. because another process already binds them")
+	flags.String("proxy-port-store-backend", def.ProxyPortStoreBackend, "Backend used to checkpoint and restore proxy port state across agent restarts (file, configmap)")
+	flags.String("proxy-port-store-configmap-namespace", def.ProxyPortStoreConfigMapNamespace, "Namespace of the ConfigMap used to checkpoint proxy port state when proxy-port-store-backend is configmap")
+}
+
+// ProxyPortRanges is the parsed, validated form of ProxyConfig, used by the
+// port allocator.
+type ProxyPortRanges struct {
+	Range PortRange
+
+	perType map[types.ProxyType]PortRange
+
+	reserved map[uint16]struct{}
+	excluded map[uint16]struct{}
+}
+
+// rangeFor returns the sub-range configured for t, or the overall range if
+// none was configured.
+func (r *ProxyPortRanges) rangeFor(t types.ProxyType) PortRange {
+	if r == nil {
+		return PortRange{}
+	}
+	if sub, ok := r.perType[t]; ok {
+		return sub
+	}
+	return r.Range
+}
+
+func (r *ProxyPortRanges) isReservedOrExcluded(port uint16) bool {
+	if r == nil {
+		return false
+	}
+	if _, ok := r.reserved[port]; ok {
+		return true
+	}
+	_, ok := r.excluded[port]
+	return ok
+}
+
+// Parse validates the ProxyConfig and returns the ProxyPortRanges derived
+// from it. Sub-ranges must fall entirely within the overall range and must
+// not overlap one another.
+func (c ProxyConfig) Parse() (*ProxyPortRanges, error) {
+	outer, err := parsePortRange(c.ProxyPortRange)
+	if err != nil {
+		return nil, fmt.Errorf("proxy-port-range: %w", err)
+	}
+
+	ranges := &ProxyPortRanges{
+		Range:    outer,
+		perType:  make(map[types.ProxyType]PortRange),
+		reserved: make(map[uint16]struct{}),
+		excluded: make(map[uint16]struct{}),
+	}
+
+	subRanges := map[types.ProxyType]string{
+		types.ProxyTypeCRD:   c.ProxyPortRangeCRD,
+		types.ProxyTypeHTTP:  c.ProxyPortRangeHTTP,
+		types.ProxyTypeDNS:   c.ProxyPortRangeDNS,
+		types.ProxyTypeKafka: c.ProxyPortRangeKafka,
+	}
+
+	for t, s := range subRanges {
+		if s == "" {
+			continue
+		}
+		sub, err := parsePortRange(s)
+		if err != nil {
+			return nil, fmt.Errorf("proxy-port-range for %s: %w", t, err)
+		}
+		if sub.Min < outer.Min || sub.Max > outer.Max {
+			return nil, fmt.Errorf("proxy-port-range for %s (%s) is not contained within proxy-port-range (%s)", t, sub, outer)
+		}
+		for other, otherSub := range ranges.perType {
+			if sub.contains(otherSub.Min) || sub.contains(otherSub.Max) || otherSub.contains(sub.Min) {
+				return nil, fmt.Errorf("proxy-port-range for %s (%s) overlaps the range for %s (%s)", t, sub, other, otherSub)
+			}
+		}
+		ranges.perType[t] = sub
+	}
+
+	reserved, err := parsePortList(c.ProxyReservedPorts)
+	if err != nil {
+		return nil, fmt.Errorf("proxy-reserved-ports: %w", err)
+	}
+	for _, port := range reserved {
+		if !outer.contains(port) {
+			return nil, fmt.Errorf("proxy-reserved-ports: port %d is outside of proxy-port-range (%s)", port, outer)
+		}
+		ranges.reserved[port] = struct{}{}
+	}
+
+	excluded, err := parsePortList(c.ProxyExcludedPorts)
+	if err != nil {
+		return nil, fmt.Errorf("proxy-excluded-ports: %w", err)
+	}
+	for _, port := range excluded {
+		ranges.excluded[port] = struct{}{}
+	}
+
+	return ranges, nil
+}
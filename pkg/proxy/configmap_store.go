@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sApiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	k8sClient "github.com/cilium/cilium/pkg/k8s/client"
+	"github.com/cilium/cilium/pkg/time"
+)
+
+// checkpointConfigMapDataKey is the ConfigMap data key the checkpoint JSON
+// blob is stored under.
+const checkpointConfigMapDataKey = "proxy-ports"
+
+// configMapProxyPortStore is a ProxyPortStore backed by a per-node
+// Kubernetes ConfigMap, so that proxy port assignments survive RunDir loss
+// (e.g. ephemeral nodes) and can be inspected cluster-wide.
+type configMapProxyPortStore struct {
+	client    k8sClient.Clientset
+	namespace string
+	name      string
+}
+
+// NewConfigMapProxyPortStore returns a ProxyPortStore that checkpoints to
+// the named ConfigMap in namespace, creating it on the first Checkpoint if
+// it does not already exist.
+func NewConfigMapProxyPortStore(client k8sClient.Clientset, namespace, name string) ProxyPortStore {
+	return &configMapProxyPortStore{client: client, namespace: namespace, name: name}
+}
+
+func (s *configMapProxyPortStore) Checkpoint(ctx context.Context, entries []CheckpointEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("marshaling proxy port checkpoint: %w", err)
+	}
+
+	cms := s.client.CoreV1().ConfigMaps(s.namespace)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+		Data:       map[string]string{checkpointConfigMapDataKey: string(data)},
+	}
+
+	if _, err := cms.Update(ctx, cm, metav1.UpdateOptions{}); k8sApiErrors.IsNotFound(err) {
+		_, err = cms.Create(ctx, cm, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("creating ConfigMap %s/%s for proxy port checkpoint: %w", s.namespace, s.name, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("updating ConfigMap %s/%s for proxy port checkpoint: %w", s.namespace, s.name, err)
+	}
+
+	return nil
+}
+
+func (s *configMapProxyPortStore) Restore(ctx context.Context, ageLimit time.Duration) ([]CheckpointEntry, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if k8sApiErrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching ConfigMap %s/%s for proxy port checkpoint: %w", s.namespace, s.name, err)
+	}
+
+	data, ok := cm.Data[checkpointConfigMapDataKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var entries []CheckpointEntry
+	if err := json.Unmarshal([]byte(data), &entries); err != nil {
+		return nil, fmt.Errorf("unmarshaling proxy port checkpoint from ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+
+	return filterByAge(entries, ageLimit), nil
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+
+	"github.com/cilium/cilium/pkg/fqdn/dnsproxy"
+	"github.com/cilium/cilium/pkg/hive/cell"
+	"github.com/cilium/cilium/pkg/policy"
+	"github.com/cilium/cilium/pkg/proxy/types"
+)
+
+// dnsBackend is the native Go L7Backend for DNS listeners, backed by
+// Cilium's built-in DNS proxy (no external Envoy process involved).
+type dnsBackend struct {
+	proxy *dnsproxy.DNSProxy
+}
+
+type dnsBackendOut struct {
+	cell.Out
+
+	Backend L7Backend `group:"proxy-l7-backends"`
+}
+
+func newDNSBackend() dnsBackendOut {
+	return dnsBackendOut{Backend: &dnsBackend{}}
+}
+
+func (b *dnsBackend) ProxyTypes() []types.ProxyType {
+	return []types.ProxyType{types.ProxyTypeDNS}
+}
+
+func (b *dnsBackend) Start(ctx context.Context) error {
+	return nil
+}
+
+func (b *dnsBackend) Stop() {
+	if b.proxy != nil {
+		b.proxy.Cleanup()
+	}
+}
+
+func (b *dnsBackend) UpsertListener(name string, pp *ProxyPort, l4 policy.ProxyPolicy) error {
+	// The DNS proxy listens on a single static, reserved port for all
+	// endpoints, configured by the caller rather than per-listener, so
+	// there is nothing additional to do here beyond having been allocated
+	// that reserved port.
+	return nil
+}
+
+func (b *dnsBackend) RemoveListener(name string) error {
+	return nil
+}
+
+func (b *dnsBackend) AccessLogSink() AccessLogSink {
+	return nil
+}
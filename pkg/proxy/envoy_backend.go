@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cilium/cilium/pkg/envoy"
+	"github.com/cilium/cilium/pkg/hive/cell"
+	"github.com/cilium/cilium/pkg/ipcache"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/policy"
+	"github.com/cilium/cilium/pkg/proxy/types"
+)
+
+// envoyBackend is the L7Backend backed by Cilium's co-located Envoy
+// instance. It owns the Envoy xDS and AccessLog gRPC servers and handles
+// HTTP and Kafka listeners.
+type envoyBackend struct {
+	ipCache *ipcache.IPCache
+	runDir  string
+
+	xdsServer       *envoy.XDSServer
+	accessLogServer *envoy.AccessLogServer
+}
+
+type envoyBackendParams struct {
+	cell.In
+
+	IPCache *ipcache.IPCache
+}
+
+type envoyBackendOut struct {
+	cell.Out
+
+	Backend L7Backend `group:"proxy-l7-backends"`
+}
+
+func newEnvoyBackend(params envoyBackendParams) envoyBackendOut {
+	return envoyBackendOut{
+		Backend: &envoyBackend{
+			ipCache: params.IPCache,
+			runDir:  option.Config.RunDir,
+		},
+	}
+}
+
+func (b *envoyBackend) ProxyTypes() []types.ProxyType {
+	return []types.ProxyType{types.ProxyTypeHTTP, types.ProxyTypeKafka}
+}
+
+func (b *envoyBackend) Start(ctx context.Context) error {
+	xdsServer, err := envoy.StartXDSServer(b.ipCache, envoy.GetSocketDir(b.runDir))
+	if err != nil {
+		return fmt.Errorf("failed to start Envoy xDS server: %w", err)
+	}
+	b.xdsServer = xdsServer
+
+	accessLogServer, err := envoy.StartAccessLogServer(envoy.GetSocketDir(b.runDir), b.xdsServer)
+	if err != nil {
+		return fmt.Errorf("failed to start Envoy AccessLog server: %w", err)
+	}
+	b.accessLogServer = accessLogServer
+
+	return nil
+}
+
+func (b *envoyBackend) Stop() {
+	if b.xdsServer != nil {
+		b.xdsServer.Stop()
+	}
+	if b.accessLogServer != nil {
+		b.accessLogServer.Stop()
+	}
+}
+
+func (b *envoyBackend) UpsertListener(name string, pp *ProxyPort, l4 policy.ProxyPolicy) error {
+	if b.xdsServer == nil {
+		return fmt.Errorf("envoy xDS server is not running")
+	}
+
+	l7Rules := l4.CopyL7RulesPerEndpoint()
+	if err := b.xdsServer.UpdateListener(name, l4.GetL7Parser(), pp.proxyPort, pp.ingress, l7Rules); err != nil {
+		return fmt.Errorf("updating Envoy listener %q: %w", name, err)
+	}
+
+	return nil
+}
+
+func (b *envoyBackend) RemoveListener(name string) error {
+	if b.xdsServer == nil {
+		return fmt.Errorf("envoy xDS server is not running")
+	}
+	return b.xdsServer.RemoveListener(name)
+}
+
+func (b *envoyBackend) AccessLogSink() AccessLogSink {
+	return b.accessLogServer
+}
@@ -0,0 +1,454 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/cilium/cilium/pkg/completion"
+	"github.com/cilium/cilium/pkg/ipcache"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/policy"
+	"github.com/cilium/cilium/pkg/proxy/endpoint"
+	"github.com/cilium/cilium/pkg/proxy/logger"
+	"github.com/cilium/cilium/pkg/proxy/types"
+	"github.com/cilium/cilium/pkg/revert"
+	"github.com/cilium/cilium/pkg/time"
+	"github.com/cilium/cilium/pkg/trigger"
+)
+
+var log = logging.DefaultLogger.WithField(logfields.LogSubsys, "proxy")
+
+// DatapathUpdater is the set of datapath operations the proxy needs in
+// order to install and query the iptables/eBPF rules that redirect traffic
+// to allocated proxy ports.
+type DatapathUpdater interface {
+	InstallProxyRules(ctx context.Context, proxyPort uint16, ingress bool, name string) error
+	SupportsOriginalSourceAddr() bool
+	GetProxyPorts() map[string]uint16
+}
+
+// ProxyPort tracks the state of a single named proxy port, e.g. the proxy
+// for a CiliumEnvoyConfig listener, or Cilium's built-in DNS proxy.
+type ProxyPort struct {
+	proxyType types.ProxyType
+	ingress   bool
+
+	// proxyPort is the port that is or was allocated for this name. It is
+	// zeroed out whenever the ProxyPort is released, but the ProxyPort
+	// itself lingers so that GetProxyPort and findProxyPortByType keep
+	// working for callers that raced the release.
+	proxyPort uint16
+
+	// isStatic is true for ports that are not dynamically allocated out of
+	// the proxy port range (e.g. reserved ports for the DNS proxy).
+	isStatic bool
+
+	// configured is true while the proxyPort above is considered valid,
+	// i.e. it has been allocated and not yet released.
+	configured bool
+
+	// rulesPort is the port that was last pushed to the datapath via
+	// AckProxyPort. It is only cleared when the name is removed entirely,
+	// so that the last-used port can be restored across restarts.
+	rulesPort uint16
+
+	// nRedirects is the number of redirects currently referencing this
+	// proxy port.
+	nRedirects int
+
+	// configVersion counts the xDS config versions generated for this
+	// proxy port by CreateOrUpdateRedirect, used to key ExternalADSServer
+	// snapshots.
+	configVersion int
+}
+
+// Proxy maintains state about redirects and manages the port allocations
+// for the various L7 proxies (Envoy, the built-in DNS proxy, ...).
+//
+// All of this state is scoped to a single Proxy instance rather than kept in
+// package-level variables: each agent process only ever constructs one
+// Proxy, but package-level state would otherwise be shared across every
+// Proxy created in a test binary for the lifetime of the process, with no
+// way for one test to avoid observing another's allocations.
+type Proxy struct {
+	mutex lock.RWMutex
+
+	// ranges holds the overall proxy port range plus any per-ProxyType
+	// sub-ranges and reserved/excluded ports configured for this agent.
+	ranges *ProxyPortRanges
+
+	runDir string
+
+	datapathUpdater DatapathUpdater
+
+	ipcache *ipcache.IPCache
+
+	endpointInfoRegistry logger.EndpointInfoRegistry
+
+	// backends holds the L7Backend registered for each ProxyType, set once
+	// at Cell start from the backends collected via hive's
+	// proxyL7BackendsGroup.
+	backends map[types.ProxyType]L7Backend
+
+	// externalADS tracks external Envoy ADS subscriber ACKs, gating
+	// AckProxyPort until at least one has ACKed the current config
+	// version. Nil when the external ADS endpoint is disabled.
+	externalADS *ExternalADSServer
+
+	proxyPortsTrigger *trigger.Trigger
+
+	proxyPortsMutex lock.RWMutex
+	proxyPorts      map[string]*ProxyPort
+
+	// allocatedPorts tracks each port value claimed within the proxy port
+	// range(s), regardless of which name it is associated with, so that the
+	// allocator never hands out the same port to two different listeners.
+	allocatedPorts map[uint16]bool
+
+	// proxyPortsCond is broadcast every time proxyPorts changes, i.e. on
+	// allocation, Ack or Release, so that WatchProxyPorts callers blocked on
+	// it wake up and recompute the hash.
+	proxyPortsCond *sync.Cond
+
+	sidecarIndexMutex lock.RWMutex
+
+	// sidecarIndex maps (endpointID, targetServiceID) to the proxy port
+	// name(s) registered as a sidecar for it. Under normal operation there
+	// is exactly one; more than one is a misconfiguration that
+	// CreateOrUpdateRedirectForSidecar rejects rather than guesses at.
+	sidecarIndex map[sidecarKey][]string
+
+	// store checkpoints and restores proxy port state across agent
+	// restarts. It defaults to a fileProxyPortStore rooted at runDir, but
+	// may be overridden (e.g. with a Kubernetes ConfigMap-backed store) via
+	// SetProxyPortStore before the proxy-ports-checkpoint controller ever
+	// runs.
+	store ProxyPortStore
+}
+
+// SetProxyPortStore overrides the ProxyPortStore used to checkpoint and
+// restore proxy port state, in place of the default file-based store
+// rooted at runDir.
+func (p *Proxy) SetProxyPortStore(store ProxyPortStore) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.store = store
+}
+
+// setBackends indexes and stores the L7Backends that CreateOrUpdateRedirect
+// dispatches to, keyed by the ProxyType each backend declares support for.
+func (p *Proxy) setBackends(backends []L7Backend) error {
+	byType, err := backendsByType(backends)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.backends = byType
+	return nil
+}
+
+// setExternalADS registers the ExternalADSServer that AckProxyPort gates
+// on, or clears it (nil) when the external ADS endpoint is disabled.
+func (p *Proxy) setExternalADS(ads *ExternalADSServer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.externalADS = ads
+}
+
+// createProxy creates a L7 proxy manager.
+func createProxy(ranges *ProxyPortRanges, runDir string, datapathUpdater DatapathUpdater, ipcacheReader *ipcache.IPCache, endpointInfoRegistry logger.EndpointInfoRegistry) *Proxy {
+	p := &Proxy{
+		ranges:               ranges,
+		runDir:               runDir,
+		datapathUpdater:      datapathUpdater,
+		ipcache:              ipcacheReader,
+		endpointInfoRegistry: endpointInfoRegistry,
+		proxyPorts:           make(map[string]*ProxyPort),
+		allocatedPorts:       make(map[uint16]bool),
+		sidecarIndex:         make(map[sidecarKey][]string),
+		store:                NewFileProxyPortStore(runDir),
+	}
+	p.proxyPortsCond = sync.NewCond(&p.proxyPortsMutex)
+	return p
+}
+
+// notifyProxyPortsChanged wakes up any callers blocked in WatchProxyPorts.
+// Callers must hold p.proxyPortsMutex for writing.
+func (p *Proxy) notifyProxyPortsChanged() {
+	p.proxyPortsCond.Broadcast()
+}
+
+// allocatePort finds an unused port to allocate for the named proxy of the
+// given type out of p.ranges, preferring the per-type sub-range (if one is
+// configured) and falling back to the overall port range otherwise. Callers
+// must hold p.proxyPortsMutex for writing.
+func (p *Proxy) allocatePort(proxyType types.ProxyType) (uint16, error) {
+	r := p.ranges.rangeFor(proxyType)
+
+	for port := r.Min; ; port++ {
+		if !p.allocatedPorts[port] && !p.ranges.isReservedOrExcluded(port) {
+			p.allocatedPorts[port] = true
+			return port, nil
+		}
+		if port == r.Max {
+			break
+		}
+	}
+
+	return 0, fmt.Errorf("no available proxy ports in range %d-%d for proxy type %q", r.Min, r.Max, proxyType)
+}
+
+// findProxyPortByType returns the name and state of the ProxyPort matching
+// the given type, ingress direction and (for non-CRD types) name.
+func (p *Proxy) findProxyPortByType(t types.ProxyType, name string, ingress bool) (string, *ProxyPort) {
+	p.proxyPortsMutex.RLock()
+	defer p.proxyPortsMutex.RUnlock()
+
+	if pp, ok := p.proxyPorts[name]; ok && pp.proxyType == t && pp.ingress == ingress {
+		return name, pp
+	}
+	return "", nil
+}
+
+// GetProxyPort returns the current proxy port allocated for the given name,
+// or an error if no such name is known.
+func (p *Proxy) GetProxyPort(name string) (uint16, error) {
+	p.proxyPortsMutex.RLock()
+	defer p.proxyPortsMutex.RUnlock()
+
+	pp, ok := p.proxyPorts[name]
+	if !ok {
+		return 0, fmt.Errorf("no proxy port found for %q", name)
+	}
+	return pp.proxyPort, nil
+}
+
+// allocateProxyPortForType allocates, or returns the already allocated,
+// proxy port for the named listener of the given ProxyType, out of that
+// type's configured sub-range if one exists and falling back to the overall
+// proxy-port-range otherwise.
+func (p *Proxy) allocateProxyPortForType(name string, proxyType types.ProxyType) (uint16, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	defer p.notifyProxyPortsChanged()
+	p.proxyPortsMutex.Lock()
+	defer p.proxyPortsMutex.Unlock()
+
+	pp, ok := p.proxyPorts[name]
+	if !ok {
+		pp = &ProxyPort{proxyType: proxyType}
+		p.proxyPorts[name] = pp
+	}
+
+	if pp.configured {
+		return pp.proxyPort, nil
+	}
+
+	port, err := p.allocatePort(pp.proxyType)
+	if err != nil {
+		return 0, err
+	}
+
+	pp.proxyPort = port
+	pp.configured = true
+	return port, nil
+}
+
+// AllocateCRDProxyPort allocates, or returns the already allocated, proxy
+// port for a CiliumEnvoyConfig listener with the given name.
+func (p *Proxy) AllocateCRDProxyPort(name string) (uint16, error) {
+	return p.allocateProxyPortForType(name, types.ProxyTypeCRD)
+}
+
+// AllocateHTTPProxyPort allocates, or returns the already allocated, proxy
+// port for an HTTP listener with the given name, out of the
+// proxy-port-range-http sub-range if one is configured.
+func (p *Proxy) AllocateHTTPProxyPort(name string) (uint16, error) {
+	return p.allocateProxyPortForType(name, types.ProxyTypeHTTP)
+}
+
+// AllocateDNSProxyPort allocates, or returns the already allocated, proxy
+// port for a DNS listener with the given name, out of the
+// proxy-port-range-dns sub-range if one is configured.
+func (p *Proxy) AllocateDNSProxyPort(name string) (uint16, error) {
+	return p.allocateProxyPortForType(name, types.ProxyTypeDNS)
+}
+
+// AllocateKafkaProxyPort allocates, or returns the already allocated, proxy
+// port for a Kafka listener with the given name, out of the
+// proxy-port-range-kafka sub-range if one is configured.
+func (p *Proxy) AllocateKafkaProxyPort(name string) (uint16, error) {
+	return p.allocateProxyPortForType(name, types.ProxyTypeKafka)
+}
+
+// AckProxyPort marks the named proxy port's currently allocated port as
+// configured in the datapath, taking a reference on it.
+func (p *Proxy) AckProxyPort(ctx context.Context, name string) error {
+	defer p.notifyProxyPortsChanged()
+
+	// Only listeners registered as a sidecar proxy are ever pushed to the
+	// external ADS endpoint, so only those need to wait for an external
+	// subscriber to ACK. Gating every listener here would block ordinary
+	// DNS/CRD/HTTP redirects on a subscriber that will never exist whenever
+	// the external ADS endpoint is enabled but no sidecar is registered.
+	if p.externalADS != nil && p.isSidecarListener(name) {
+		p.proxyPortsMutex.RLock()
+		pp, ok := p.proxyPorts[name]
+		var version string
+		if ok {
+			version = strconv.Itoa(pp.configVersion)
+		}
+		p.proxyPortsMutex.RUnlock()
+
+		if ok {
+			if err := p.externalADS.WaitForACK(ctx, name, version); err != nil {
+				return fmt.Errorf("waiting for external ADS subscriber to ACK %q: %w", name, err)
+			}
+		}
+	}
+
+	p.proxyPortsMutex.Lock()
+	defer p.proxyPortsMutex.Unlock()
+
+	pp, ok := p.proxyPorts[name]
+	if !ok {
+		return fmt.Errorf("no proxy port found for %q", name)
+	}
+
+	if err := p.datapathUpdater.InstallProxyRules(ctx, pp.proxyPort, pp.ingress, name); err != nil {
+		return fmt.Errorf("installing proxy rules for %q: %w", name, err)
+	}
+
+	pp.rulesPort = pp.proxyPort
+	pp.nRedirects++
+	return nil
+}
+
+// ReleaseProxyPort releases a reference on the named proxy port. Once the
+// last reference is released the port is freed for a future allocation to
+// pick a fresh port, but the ProxyPort itself lingers so callers can still
+// look it up.
+func (p *Proxy) ReleaseProxyPort(name string) error {
+	defer p.notifyProxyPortsChanged()
+	p.proxyPortsMutex.Lock()
+	defer p.proxyPortsMutex.Unlock()
+
+	pp, ok := p.proxyPorts[name]
+	if !ok {
+		return fmt.Errorf("no proxy port found for %q", name)
+	}
+
+	if pp.nRedirects > 0 {
+		pp.nRedirects--
+	}
+
+	if pp.nRedirects == 0 && pp.configured {
+		// Free the port back to the allocator so that a future allocation
+		// for this or another name can reuse it, instead of permanently
+		// exhausting the range as listeners churn.
+		p.allocatedPorts[pp.proxyPort] = false
+		pp.configured = false
+		pp.proxyPort = 0
+	}
+
+	return nil
+}
+
+// CreateOrUpdateRedirect creates or updates the redirect for the given
+// policy's listener, dispatching to the L7Backend registered for the
+// listener's ProxyType, and returns the allocated proxy port.
+func (p *Proxy) CreateOrUpdateRedirect(ctx context.Context, l4 policy.ProxyPolicy, proxyID string, ep endpoint.ProxyUpdater, wg *completion.WaitGroup) (uint16, error, revert.FinalizeFunc, revert.RevertFunc) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	listener := l4.GetListener()
+
+	p.proxyPortsMutex.RLock()
+	pp, ok := p.proxyPorts[listener]
+	p.proxyPortsMutex.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("listener %q not found", listener), nil, nil
+	}
+
+	backend, ok := p.backends[pp.proxyType]
+	if !ok {
+		return 0, fmt.Errorf("no L7 backend registered for proxy type %q (listener %q)", pp.proxyType, listener), nil, nil
+	}
+
+	if err := backend.UpsertListener(listener, pp, l4); err != nil {
+		return 0, fmt.Errorf("upserting listener %q on %q backend: %w", listener, pp.proxyType, err), nil, nil
+	}
+
+	pp.configVersion++
+	if p.externalADS != nil && p.isSidecarListener(listener) {
+		p.externalADS.PushSnapshot(listener, strconv.Itoa(pp.configVersion))
+	}
+
+	return pp.proxyPort, nil, nil, nil
+}
+
+// RestoreProxyPorts restores previously checkpointed proxy port state via
+// p.store, ignoring entries checkpointed more than ageLimit ago. Restored
+// ports are marked configured so that GetProxyPort and CreateOrUpdateRedirect
+// find them immediately, without waiting for a fresh AllocateCRDProxyPort.
+func (p *Proxy) RestoreProxyPorts(ageLimit time.Duration) {
+	entries, err := p.store.Restore(context.Background(), ageLimit)
+	if err != nil {
+		log.WithError(err).Warning("Unable to restore proxy port checkpoint; starting with a clean allocator")
+		return
+	}
+
+	p.proxyPortsMutex.Lock()
+	defer p.proxyPortsMutex.Unlock()
+
+	for _, e := range entries {
+		pp, ok := p.proxyPorts[e.Name]
+		if !ok {
+			pp = &ProxyPort{}
+			p.proxyPorts[e.Name] = pp
+		}
+
+		pp.proxyType = e.ProxyType
+		pp.ingress = e.Ingress
+		pp.proxyPort = e.ProxyPort
+		pp.isStatic = e.IsStatic
+		pp.configured = true
+		pp.rulesPort = e.ProxyPort
+		p.allocatedPorts[e.ProxyPort] = true
+	}
+}
+
+// storeProxyPorts checkpoints the current proxy port state via p.store, so
+// it can be restored by RestoreProxyPorts after an agent restart.
+func (p *Proxy) storeProxyPorts(ctx context.Context) error {
+	now := time.Now()
+
+	p.proxyPortsMutex.RLock()
+	entries := make([]CheckpointEntry, 0, len(p.proxyPorts))
+	for name, pp := range p.proxyPorts {
+		if !pp.configured {
+			continue
+		}
+		entries = append(entries, CheckpointEntry{
+			Name:           name,
+			ProxyType:      pp.proxyType,
+			Ingress:        pp.ingress,
+			ProxyPort:      pp.proxyPort,
+			IsStatic:       pp.isStatic,
+			CheckpointedAt: now,
+		})
+	}
+	p.proxyPortsMutex.RUnlock()
+
+	return p.store.Checkpoint(ctx, entries)
+}
@@ -11,10 +11,12 @@ import (
 	"github.com/cilium/cilium/pkg/completion"
 	"github.com/cilium/cilium/pkg/envoy"
 	"github.com/cilium/cilium/pkg/identity"
+	k8sClient "github.com/cilium/cilium/pkg/k8s/client"
 	"github.com/cilium/cilium/pkg/policy"
 	endpointtest "github.com/cilium/cilium/pkg/proxy/endpoint/test"
 	"github.com/cilium/cilium/pkg/proxy/types"
 	testipcache "github.com/cilium/cilium/pkg/testutils/ipcache"
+	"github.com/cilium/cilium/pkg/time"
 	"github.com/cilium/cilium/pkg/u8proto"
 
 	. "github.com/cilium/checkmate"
@@ -48,13 +50,16 @@ func (s *ProxySuite) TestPortAllocator(c *C) {
 	err := os.MkdirAll(socketDir, 0700)
 	c.Assert(err, IsNil)
 
-	p := createProxy(10000, 20000, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
 
 	port, err := p.AllocateCRDProxyPort("listener1")
 	c.Assert(err, IsNil)
 	c.Assert(port, Not(Equals), 0)
 
-	port1, err := GetProxyPort("listener1")
+	port1, err := p.GetProxyPort("listener1")
 	c.Assert(err, IsNil)
 	c.Assert(port1, Equals, port)
 
@@ -63,7 +68,7 @@ func (s *ProxySuite) TestPortAllocator(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(port1a, Equals, port1)
 
-	name, pp := findProxyPortByType(types.ProxyTypeCRD, "listener1", false)
+	name, pp := p.findProxyPortByType(types.ProxyTypeCRD, "listener1", false)
 	c.Assert(name, Equals, "listener1")
 	c.Assert(pp.proxyType, Equals, types.ProxyTypeCRD)
 	c.Assert(pp.proxyPort, Equals, port)
@@ -77,7 +82,7 @@ func (s *ProxySuite) TestPortAllocator(c *C) {
 	c.Assert(err, IsNil)
 
 	// ProxyPort lingers and can still be found, but it's port is zeroed
-	port1b, err := GetProxyPort("listener1")
+	port1b, err := p.GetProxyPort("listener1")
 	c.Assert(err, IsNil)
 	c.Assert(port1b, Equals, uint16(0))
 	c.Assert(pp.proxyPort, Equals, uint16(0))
@@ -91,7 +96,7 @@ func (s *ProxySuite) TestPortAllocator(c *C) {
 	port2, err := p.AllocateCRDProxyPort("listener1")
 	c.Assert(err, IsNil)
 	c.Assert(port2, Not(Equals), port)
-	name2, pp2 := findProxyPortByType(types.ProxyTypeCRD, "listener1", false)
+	name2, pp2 := p.findProxyPortByType(types.ProxyTypeCRD, "listener1", false)
 	c.Assert(name2, Equals, name)
 	c.Assert(pp2, Equals, pp)
 	c.Assert(pp.proxyType, Equals, types.ProxyTypeCRD)
@@ -139,7 +144,7 @@ func (s *ProxySuite) TestPortAllocator(c *C) {
 	c.Assert(pp.rulesPort, Equals, port2)
 
 	// mimic some other process taking the port
-	allocatedPorts[port2] = true
+	p.allocatedPorts[port2] = true
 
 	// Allocate again, this time a different port is allocated
 	port3, err := p.AllocateCRDProxyPort("listener1")
@@ -147,7 +152,7 @@ func (s *ProxySuite) TestPortAllocator(c *C) {
 	c.Assert(port3, Not(Equals), uint16(0))
 	c.Assert(port3, Not(Equals), port2)
 	c.Assert(port3, Not(Equals), port1)
-	name2, pp2 = findProxyPortByType(types.ProxyTypeCRD, "listener1", false)
+	name2, pp2 = p.findProxyPortByType(types.ProxyTypeCRD, "listener1", false)
 	c.Assert(name2, Equals, name)
 	c.Assert(pp2, Equals, pp)
 	c.Assert(pp.proxyType, Equals, types.ProxyTypeCRD)
@@ -172,7 +177,7 @@ func (s *ProxySuite) TestPortAllocator(c *C) {
 	c.Assert(pp.proxyPort, Equals, uint16(0))
 	c.Assert(pp.rulesPort, Equals, port3)
 
-	inuse, exists := allocatedPorts[port3]
+	inuse, exists := p.allocatedPorts[port3]
 	c.Assert(exists, Equals, true)
 	c.Assert(inuse, Equals, false)
 
@@ -223,7 +228,10 @@ func (s *ProxySuite) TestCreateOrUpdateRedirectMissingListener(c *C) {
 	err := os.MkdirAll(socketDir, 0700)
 	c.Assert(err, IsNil)
 
-	p := createProxy(10000, 20000, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
 
 	ep := &endpointtest.ProxyUpdaterMock{
 		Id:       1000,
@@ -244,3 +252,481 @@ func (s *ProxySuite) TestCreateOrUpdateRedirectMissingListener(c *C) {
 	c.Assert(finalizeFunc, IsNil)
 	c.Assert(revertFunc, IsNil)
 }
+
+type fakeProxyPolicyForListener struct {
+	fakeProxyPolicy
+	listener string
+}
+
+func (p *fakeProxyPolicyForListener) GetListener() string {
+	return p.listener
+}
+
+func (s *ProxySuite) TestCreateOrUpdateRedirectMissingBackend(c *C) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+
+	// The listener exists, but no L7Backend was ever registered with
+	// setBackends, so CreateOrUpdateRedirect must fail with a distinct
+	// "missing backend" error rather than "listener not found".
+	_, err = p.AllocateCRDProxyPort("listener1")
+	c.Assert(err, IsNil)
+
+	ep := &endpointtest.ProxyUpdaterMock{
+		Id:       1000,
+		Ipv4:     "10.0.0.1",
+		Ipv6:     "f00d::1",
+		Labels:   []string{"id.foo", "id.bar"},
+		Identity: identity.NumericIdentity(123),
+	}
+
+	l4 := &fakeProxyPolicyForListener{listener: "listener1"}
+
+	ctx := context.TODO()
+	wg := completion.NewWaitGroup(ctx)
+
+	proxyPort, err, finalizeFunc, revertFunc := p.CreateOrUpdateRedirect(ctx, l4, "dummy-proxy-id", ep, wg)
+	c.Assert(proxyPort, Equals, uint16(0))
+	c.Assert(err, NotNil)
+	c.Assert(finalizeFunc, IsNil)
+	c.Assert(revertFunc, IsNil)
+}
+
+func (s *ProxySuite) TestCreateOrUpdateRedirectForSidecarMissing(c *C) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+
+	ep := &endpointtest.ProxyUpdaterMock{
+		Id:       1000,
+		Ipv4:     "10.0.0.1",
+		Ipv6:     "f00d::1",
+		Labels:   []string{"id.foo", "id.bar"},
+		Identity: identity.NumericIdentity(123),
+	}
+
+	l4 := &fakeProxyPolicy{}
+	ctx := context.TODO()
+	wg := completion.NewWaitGroup(ctx)
+
+	// No sidecar was ever registered for this endpoint/service pair.
+	proxyPort, err, finalizeFunc, revertFunc := p.CreateOrUpdateRedirectForSidecar(ctx, "target-svc", l4, ep, wg)
+	c.Assert(proxyPort, Equals, uint16(0))
+	c.Assert(err, ErrorMatches, `no sidecar proxy registered for endpoint 1000 and target service "target-svc"`)
+	c.Assert(finalizeFunc, IsNil)
+	c.Assert(revertFunc, IsNil)
+}
+
+func (s *ProxySuite) TestCreateOrUpdateRedirectForSidecarAmbiguous(c *C) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+
+	ep := &endpointtest.ProxyUpdaterMock{
+		Id:       1000,
+		Ipv4:     "10.0.0.1",
+		Ipv6:     "f00d::1",
+		Labels:   []string{"id.foo", "id.bar"},
+		Identity: identity.NumericIdentity(123),
+	}
+
+	_, err = p.AllocateCRDProxyPort("sidecar1")
+	c.Assert(err, IsNil)
+	_, err = p.AllocateCRDProxyPort("sidecar2")
+	c.Assert(err, IsNil)
+
+	c.Assert(p.RegisterSidecarProxy("sidecar1", ep.GetID(), "target-svc"), IsNil)
+	c.Assert(p.RegisterSidecarProxy("sidecar2", ep.GetID(), "target-svc"), IsNil)
+
+	l4 := &fakeProxyPolicy{}
+	ctx := context.TODO()
+	wg := completion.NewWaitGroup(ctx)
+
+	proxyPort, err, finalizeFunc, revertFunc := p.CreateOrUpdateRedirectForSidecar(ctx, "target-svc", l4, ep, wg)
+	c.Assert(proxyPort, Equals, uint16(0))
+	c.Assert(err, ErrorMatches, `ambiguous sidecar proxy for endpoint 1000 and target service "target-svc": 2 candidates found \[sidecar1 sidecar2\]`)
+	c.Assert(finalizeFunc, IsNil)
+	c.Assert(revertFunc, IsNil)
+}
+
+// watchProxyPortsResult carries back the return values of a WatchProxyPorts
+// call made on a background goroutine.
+type watchProxyPortsResult struct {
+	ports map[string]ProxyPortInfo
+	hash  string
+	err   error
+}
+
+// watchProxyPortsInBackground starts a WatchProxyPorts(ctx, prevHash) call on
+// a goroutine and returns a channel the result is delivered on once it
+// returns. The caller must give the goroutine a chance to actually reach
+// proxyPortsCond.Wait() (e.g. by sleeping briefly) before mutating
+// proxyPorts, otherwise the watch may take the immediate-return fast path
+// instead of exercising the blocking/wakeup path under test.
+func watchProxyPortsInBackground(p *Proxy, ctx context.Context, prevHash string) <-chan watchProxyPortsResult {
+	done := make(chan watchProxyPortsResult, 1)
+	go func() {
+		ports, hash, err := p.WatchProxyPorts(ctx, prevHash)
+		done <- watchProxyPortsResult{ports: ports, hash: hash, err: err}
+	}()
+	return done
+}
+
+func (s *ProxySuite) TestWatchProxyPortsUnblocksOnAckAndRelease(c *C) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+
+	_, err = p.AllocateCRDProxyPort("listener1")
+	c.Assert(err, IsNil)
+
+	_, hash, err := p.WatchProxyPorts(context.TODO(), "")
+	c.Assert(err, IsNil)
+
+	// Start the watch first and give it time to actually block in
+	// proxyPortsCond.Wait() before AckProxyPort changes the map, so that
+	// this test would fail if the blocking/wakeup path were removed.
+	watchDone := watchProxyPortsInBackground(p, context.TODO(), hash)
+	time.Sleep(10 * time.Millisecond)
+
+	c.Assert(p.AckProxyPort(context.TODO(), "listener1"), IsNil)
+
+	var res watchProxyPortsResult
+	select {
+	case res = <-watchDone:
+	case <-time.After(time.Second):
+		c.Fatal("WatchProxyPorts did not unblock after AckProxyPort")
+	}
+	c.Assert(res.err, IsNil)
+	c.Assert(res.hash, Not(Equals), hash)
+	c.Assert(res.ports["listener1"].Configured, Equals, true)
+
+	watchDone = watchProxyPortsInBackground(p, context.TODO(), res.hash)
+	time.Sleep(10 * time.Millisecond)
+
+	c.Assert(p.ReleaseProxyPort("listener1"), IsNil)
+
+	var res2 watchProxyPortsResult
+	select {
+	case res2 = <-watchDone:
+	case <-time.After(time.Second):
+		c.Fatal("WatchProxyPorts did not unblock after ReleaseProxyPort")
+	}
+	c.Assert(res2.err, IsNil)
+	c.Assert(res2.hash, Not(Equals), res.hash)
+	c.Assert(res2.ports["listener1"].Configured, Equals, false)
+}
+
+func (s *ProxySuite) TestWatchProxyPortsContextCancellation(c *C) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+
+	_, hash, err := p.WatchProxyPorts(context.TODO(), "")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+
+	_, _, err = p.WatchProxyPorts(ctx, hash)
+	c.Assert(err, NotNil)
+}
+
+// fakeADSSubscriber mimics an external Envoy sidecar's ADS stream: it
+// observes the config version pushed for a proxy-id and ACKs it, just like
+// a real subscriber would after applying the corresponding DiscoveryResponse.
+type fakeADSSubscriber struct {
+	node identity.NumericIdentity
+	ads  *ExternalADSServer
+}
+
+func (f *fakeADSSubscriber) ackWhenPushed(proxyID, version string) {
+	f.ads.PushSnapshot(proxyID, version)
+	_ = f.ads.Ack(f.node, proxyID, version)
+}
+
+func (s *ProxySuite) TestAckProxyPortGatedByExternalADS(c *C) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+
+	authorized := identity.NumericIdentity(42)
+	ads := NewExternalADSServer(map[identity.NumericIdentity]bool{authorized: true})
+	p.setExternalADS(ads)
+
+	_, err = p.AllocateCRDProxyPort("listener1")
+	c.Assert(err, IsNil)
+
+	// Only listeners registered as a sidecar proxy are gated on external ADS
+	// ACKs; register this one so AckProxyPort actually waits below.
+	c.Assert(p.RegisterSidecarProxy("listener1", 1000, "target-svc"), IsNil)
+
+	// Simulate CreateOrUpdateRedirect having already pushed a config
+	// snapshot for "listener1" (its configVersion starts at 0).
+	ads.PushSnapshot("listener1", "0")
+
+	// No subscriber has ACKed any version yet for "listener1", so
+	// AckProxyPort must not return until one does (or the context expires).
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	c.Assert(p.AckProxyPort(ctx, "listener1"), NotNil)
+
+	// An unauthorized node's ACK must not satisfy the gate.
+	unauthorized := &fakeADSSubscriber{node: identity.NumericIdentity(7), ads: ads}
+	unauthorized.ackWhenPushed("listener1", "0")
+
+	ctx2, cancel2 := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel2()
+	c.Assert(p.AckProxyPort(ctx2, "listener1"), NotNil)
+
+	// Once the authorized subscriber ACKs the pushed version, AckProxyPort
+	// unblocks. AllocateCRDProxyPort never went through
+	// CreateOrUpdateRedirect, so the pending config version is still "0".
+	subscriber := &fakeADSSubscriber{node: authorized, ads: ads}
+	subscriber.ackWhenPushed("listener1", "0")
+
+	ctx3, cancel3 := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel3()
+	c.Assert(p.AckProxyPort(ctx3, "listener1"), IsNil)
+}
+
+// TestAckProxyPortNotGatedForNonSidecarListener ensures that enabling the
+// external ADS endpoint does not block ordinary (non-sidecar) redirects on
+// an external subscriber that will never connect for them.
+func (s *ProxySuite) TestAckProxyPortNotGatedForNonSidecarListener(c *C) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+	p.setExternalADS(NewExternalADSServer(nil))
+
+	_, err = p.AllocateCRDProxyPort("crd-listener")
+	c.Assert(err, IsNil)
+
+	// "crd-listener" was never registered as a sidecar proxy, so it must
+	// never be gated on an external ADS subscriber, even though the
+	// external ADS endpoint is enabled and no subscriber has ever ACKed
+	// anything for it.
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	c.Assert(p.AckProxyPort(ctx, "crd-listener"), IsNil)
+}
+
+func (s *ProxySuite) TestPortAllocatorSubRangeIsolation(c *C) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{
+		ProxyPortRange:      "10000-10010",
+		ProxyPortRangeCRD:   "10000-10002",
+		ProxyPortRangeHTTP:  "10003-10005",
+		ProxyPortRangeDNS:   "10006-10007",
+		ProxyPortRangeKafka: "10008-10009",
+	}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+
+	port, err := p.AllocateCRDProxyPort("crd-listener")
+	c.Assert(err, IsNil)
+	c.Assert(port >= 10000 && port <= 10002, Equals, true)
+
+	port, err = p.AllocateHTTPProxyPort("http-listener")
+	c.Assert(err, IsNil)
+	c.Assert(port >= 10003 && port <= 10005, Equals, true)
+
+	port, err = p.AllocateDNSProxyPort("dns-listener")
+	c.Assert(err, IsNil)
+	c.Assert(port >= 10006 && port <= 10007, Equals, true)
+
+	port, err = p.AllocateKafkaProxyPort("kafka-listener")
+	c.Assert(err, IsNil)
+	c.Assert(port >= 10008 && port <= 10009, Equals, true)
+
+	// The 10010 port falls outside of every sub-range, so it remains
+	// reachable only through the overall range, e.g. for a CiliumEnvoyConfig
+	// listener allocated after every sub-range is exhausted.
+	name, pp := p.findProxyPortByType(types.ProxyTypeHTTP, "http-listener", false)
+	c.Assert(name, Equals, "http-listener")
+	c.Assert(pp.proxyType, Equals, types.ProxyTypeHTTP)
+}
+
+func (s *ProxySuite) TestPortAllocatorExhaustion(c *C) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-10001"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+
+	_, err = p.AllocateCRDProxyPort("listener1")
+	c.Assert(err, IsNil)
+	_, err = p.AllocateCRDProxyPort("listener2")
+	c.Assert(err, IsNil)
+
+	_, err = p.AllocateCRDProxyPort("listener3")
+	c.Assert(err, NotNil)
+}
+
+func (s *ProxySuite) TestProxyConfigParseValidation(c *C) {
+	_, err := ProxyConfig{ProxyPortRange: "10000-20000", ProxyPortRangeCRD: "9000-9500"}.Parse()
+	c.Assert(err, NotNil)
+
+	_, err = ProxyConfig{
+		ProxyPortRange:     "10000-20000",
+		ProxyPortRangeCRD:  "10000-10100",
+		ProxyPortRangeHTTP: "10050-10150",
+	}.Parse()
+	c.Assert(err, NotNil)
+
+	_, err = ProxyConfig{ProxyPortRange: "10000-20000", ProxyReservedPorts: "9999"}.Parse()
+	c.Assert(err, NotNil)
+
+	ranges, err := ProxyConfig{
+		ProxyPortRange:     "10000-20000",
+		ProxyPortRangeCRD:  "10000-10999",
+		ProxyPortRangeHTTP: "11000-11999",
+		ProxyReservedPorts: "10500",
+		ProxyExcludedPorts: "10501,10502",
+	}.Parse()
+	c.Assert(err, IsNil)
+	c.Assert(ranges.Range, Equals, PortRange{Min: 10000, Max: 20000})
+}
+
+// testProxyPortStoreRoundTrip allocates and acks a proxy port on p, then
+// checkpoints and restores it via store into a freshly created Proxy,
+// asserting that the restored port matches what was allocated.
+func testProxyPortStoreRoundTrip(c *C, store ProxyPortStore) {
+	mockDatapathUpdater := &MockDatapathUpdater{}
+
+	testRunDir := c.MkDir()
+	socketDir := envoy.GetSocketDir(testRunDir)
+	err := os.MkdirAll(socketDir, 0700)
+	c.Assert(err, IsNil)
+
+	ranges, err := ProxyConfig{ProxyPortRange: "10000-20000"}.Parse()
+	c.Assert(err, IsNil)
+
+	p := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+	p.SetProxyPortStore(store)
+
+	port, err := p.AllocateCRDProxyPort("listener1")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	c.Assert(p.AckProxyPort(ctx, "listener1"), IsNil)
+
+	c.Assert(p.storeProxyPorts(context.TODO()), IsNil)
+
+	// Simulate an agent restart: a fresh Proxy and allocator state, backed
+	// by the same store.
+	p2 := createProxy(ranges, testRunDir, mockDatapathUpdater, testipcache.NewMockIPCache(), nil)
+	p2.SetProxyPortStore(store)
+	p2.RestoreProxyPorts(0)
+
+	restoredPort, err := p2.GetProxyPort("listener1")
+	c.Assert(err, IsNil)
+	c.Assert(restoredPort, Equals, port)
+
+	// The restored port must not be handed out again to a new listener.
+	_, err = p2.AllocateCRDProxyPort("listener2")
+	c.Assert(err, IsNil)
+	otherPort, err := p2.GetProxyPort("listener2")
+	c.Assert(err, IsNil)
+	c.Assert(otherPort, Not(Equals), restoredPort)
+}
+
+func (s *ProxySuite) TestProxyPortStoreRoundTripFile(c *C) {
+	testProxyPortStoreRoundTrip(c, NewFileProxyPortStore(c.MkDir()))
+}
+
+func (s *ProxySuite) TestProxyPortStoreRoundTripMem(c *C) {
+	testProxyPortStoreRoundTrip(c, NewMemProxyPortStore())
+}
+
+func (s *ProxySuite) TestProxyPortStoreRoundTripConfigMap(c *C) {
+	client := k8sClient.NewFakeClientset()
+	testProxyPortStoreRoundTrip(c, NewConfigMapProxyPortStore(client, "kube-system", "cilium-proxy-ports-test-node"))
+}
+
+func (s *ProxySuite) TestProxyPortStoreRestoreHonorsAgeLimit(c *C) {
+	store := NewMemProxyPortStore()
+
+	c.Assert(store.Checkpoint(context.TODO(), []CheckpointEntry{
+		{Name: "stale-listener", ProxyType: types.ProxyTypeCRD, ProxyPort: 10123, CheckpointedAt: time.Now().Add(-time.Hour)},
+	}), IsNil)
+
+	entries, err := store.Restore(context.TODO(), time.Minute)
+	c.Assert(err, IsNil)
+	c.Assert(len(entries), Equals, 0)
+
+	entries, err = store.Restore(context.TODO(), 0)
+	c.Assert(err, IsNil)
+	c.Assert(len(entries), Equals, 1)
+}
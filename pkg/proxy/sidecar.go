@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cilium/cilium/pkg/completion"
+	"github.com/cilium/cilium/pkg/policy"
+	"github.com/cilium/cilium/pkg/proxy/endpoint"
+	"github.com/cilium/cilium/pkg/revert"
+)
+
+// sidecarKey identifies the unique proxy registered as a sidecar for a
+// given endpoint/target-service pair.
+type sidecarKey struct {
+	endpointID      uint16
+	targetServiceID string
+}
+
+// RegisterSidecarProxy marks the already-allocated proxy port name as the
+// sidecar for endpointID and targetServiceID, so that
+// CreateOrUpdateRedirectForSidecar can resolve it automatically.
+func (p *Proxy) RegisterSidecarProxy(name string, endpointID uint16, targetServiceID string) error {
+	p.proxyPortsMutex.RLock()
+	_, ok := p.proxyPorts[name]
+	p.proxyPortsMutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("no proxy port found for %q", name)
+	}
+
+	key := sidecarKey{endpointID: endpointID, targetServiceID: targetServiceID}
+
+	p.sidecarIndexMutex.Lock()
+	defer p.sidecarIndexMutex.Unlock()
+	for _, existing := range p.sidecarIndex[key] {
+		if existing == name {
+			return nil
+		}
+	}
+	p.sidecarIndex[key] = append(p.sidecarIndex[key], name)
+	return nil
+}
+
+// UnregisterSidecarProxy removes name from the sidecar index for
+// endpointID and targetServiceID, e.g. when the sidecar's redirect is torn
+// down.
+func (p *Proxy) UnregisterSidecarProxy(name string, endpointID uint16, targetServiceID string) {
+	key := sidecarKey{endpointID: endpointID, targetServiceID: targetServiceID}
+
+	p.sidecarIndexMutex.Lock()
+	defer p.sidecarIndexMutex.Unlock()
+
+	names := p.sidecarIndex[key]
+	for i, existing := range names {
+		if existing == name {
+			p.sidecarIndex[key] = append(names[:i:i], names[i+1:]...)
+			break
+		}
+	}
+	if len(p.sidecarIndex[key]) == 0 {
+		delete(p.sidecarIndex, key)
+	}
+}
+
+// isSidecarListener reports whether name is currently registered as the
+// sidecar proxy for any endpoint/target-service pair, i.e. a listener
+// actually exposed to external Envoy subscribers rather than one only ever
+// consumed by Cilium's co-located Envoy.
+func (p *Proxy) isSidecarListener(name string) bool {
+	p.sidecarIndexMutex.RLock()
+	defer p.sidecarIndexMutex.RUnlock()
+
+	for _, names := range p.sidecarIndex {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveSidecarListener returns the unique proxy port name registered as
+// the sidecar for endpointID and targetServiceID.
+func (p *Proxy) resolveSidecarListener(endpointID uint16, targetServiceID string) (string, error) {
+	key := sidecarKey{endpointID: endpointID, targetServiceID: targetServiceID}
+
+	p.sidecarIndexMutex.RLock()
+	defer p.sidecarIndexMutex.RUnlock()
+
+	switch names := p.sidecarIndex[key]; len(names) {
+	case 0:
+		return "", fmt.Errorf("no sidecar proxy registered for endpoint %d and target service %q", endpointID, targetServiceID)
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous sidecar proxy for endpoint %d and target service %q: %d candidates found %v", endpointID, targetServiceID, len(names), names)
+	}
+}
+
+// sidecarProxyPolicy decorates a policy.ProxyPolicy to report the listener
+// name resolved by CreateOrUpdateRedirectForSidecar, overriding whatever
+// (if anything) the wrapped policy itself carries.
+type sidecarProxyPolicy struct {
+	policy.ProxyPolicy
+	listener string
+}
+
+func (s *sidecarProxyPolicy) GetListener() string {
+	return s.listener
+}
+
+// CreateOrUpdateRedirectForSidecar creates or updates the redirect for the
+// unique proxy registered as a sidecar for targetServiceID on ep, resolving
+// the listener name automatically instead of requiring the caller to
+// supply a fully-qualified proxy-id.
+func (p *Proxy) CreateOrUpdateRedirectForSidecar(ctx context.Context, targetServiceID string, l4 policy.ProxyPolicy, ep endpoint.ProxyUpdater, wg *completion.WaitGroup) (uint16, error, revert.FinalizeFunc, revert.RevertFunc) {
+	listener, err := p.resolveSidecarListener(ep.GetID(), targetServiceID)
+	if err != nil {
+		return 0, err, nil, nil
+	}
+
+	return p.CreateOrUpdateRedirect(ctx, &sidecarProxyPolicy{ProxyPolicy: l4, listener: listener}, listener, ep, wg)
+}
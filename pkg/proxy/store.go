@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/proxy/types"
+	"github.com/cilium/cilium/pkg/time"
+)
+
+// checkpointFileName is the file a fileProxyPortStore checkpoints proxy
+// port state to, relative to its RunDir. This is the same on-disk format
+// used before the checkpoint path became pluggable.
+const checkpointFileName = "proxy_ports_state.json"
+
+// CheckpointEntry is the serializable snapshot of a single allocated proxy
+// port, as persisted and restored by a ProxyPortStore.
+type CheckpointEntry struct {
+	Name           string          `json:"name"`
+	ProxyType      types.ProxyType `json:"proxyType"`
+	Ingress        bool            `json:"ingress"`
+	ProxyPort      uint16          `json:"proxyPort"`
+	IsStatic       bool            `json:"isStatic"`
+	CheckpointedAt time.Time       `json:"checkpointedAt"`
+}
+
+// ProxyPortStore persists and restores the set of allocated proxy ports
+// across agent restarts, so RestoreProxyPorts can hand listeners back the
+// same ports they held before instead of allocating fresh ones (and
+// briefly invalidating the datapath's existing redirect rules).
+type ProxyPortStore interface {
+	// Checkpoint persists entries, replacing whatever was previously
+	// checkpointed.
+	Checkpoint(ctx context.Context, entries []CheckpointEntry) error
+
+	// Restore returns the most recently checkpointed entries, excluding
+	// any entry checkpointed more than ageLimit ago. An ageLimit of zero
+	// disables the age filter.
+	Restore(ctx context.Context, ageLimit time.Duration) ([]CheckpointEntry, error)
+}
+
+// filterByAge drops entries checkpointed more than ageLimit ago.
+func filterByAge(entries []CheckpointEntry, ageLimit time.Duration) []CheckpointEntry {
+	if ageLimit <= 0 {
+		return entries
+	}
+
+	cutoff := time.Now().Add(-ageLimit)
+	fresh := entries[:0]
+	for _, e := range entries {
+		if e.CheckpointedAt.After(cutoff) {
+			fresh = append(fresh, e)
+		}
+	}
+	return fresh
+}
+
+// fileProxyPortStore is the default ProxyPortStore, checkpointing to a JSON
+// file under RunDir.
+type fileProxyPortStore struct {
+	path string
+}
+
+// NewFileProxyPortStore returns a ProxyPortStore that checkpoints to a JSON
+// file under runDir.
+func NewFileProxyPortStore(runDir string) ProxyPortStore {
+	return &fileProxyPortStore{path: filepath.Join(runDir, checkpointFileName)}
+}
+
+func (s *fileProxyPortStore) Checkpoint(ctx context.Context, entries []CheckpointEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("marshaling proxy port checkpoint: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("writing proxy port checkpoint to %q: %w", s.path, err)
+	}
+	return nil
+}
+
+func (s *fileProxyPortStore) Restore(ctx context.Context, ageLimit time.Duration) ([]CheckpointEntry, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading proxy port checkpoint from %q: %w", s.path, err)
+	}
+
+	var entries []CheckpointEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("unmarshaling proxy port checkpoint from %q: %w", s.path, err)
+	}
+
+	return filterByAge(entries, ageLimit), nil
+}
+
+// memProxyPortStore is a ProxyPortStore that only keeps entries in memory,
+// for use in unit tests that don't want to touch the filesystem or a
+// Kubernetes apiserver.
+type memProxyPortStore struct {
+	mutex   lock.Mutex
+	entries []CheckpointEntry
+}
+
+// NewMemProxyPortStore returns an in-memory ProxyPortStore.
+func NewMemProxyPortStore() ProxyPortStore {
+	return &memProxyPortStore{}
+}
+
+func (s *memProxyPortStore) Checkpoint(ctx context.Context, entries []CheckpointEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = append([]CheckpointEntry(nil), entries...)
+	return nil
+}
+
+func (s *memProxyPortStore) Restore(ctx context.Context, ageLimit time.Duration) ([]CheckpointEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return filterByAge(append([]CheckpointEntry(nil), s.entries...), ageLimit), nil
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/cilium/cilium/pkg/proxy/types"
+)
+
+// ProxyPortInfo is the subset of ProxyPort state exposed to WatchProxyPorts
+// callers, e.g. the CNI or operator, who only care about the allocated port
+// and whether it has been acked to the datapath.
+type ProxyPortInfo struct {
+	ProxyType  types.ProxyType `json:"proxyType"`
+	Ingress    bool            `json:"ingress"`
+	ProxyPort  uint16          `json:"proxyPort"`
+	Configured bool            `json:"configured"`
+}
+
+// snapshotProxyPorts returns the current ProxyPortInfo for every known name
+// together with a stable hash of that snapshot. Callers must hold
+// p.proxyPortsMutex for reading or writing.
+func (p *Proxy) snapshotProxyPorts() (map[string]ProxyPortInfo, string) {
+	snapshot := make(map[string]ProxyPortInfo, len(p.proxyPorts))
+	names := make([]string, 0, len(p.proxyPorts))
+	for name, pp := range p.proxyPorts {
+		snapshot[name] = ProxyPortInfo{
+			ProxyType:  pp.proxyType,
+			Ingress:    pp.ingress,
+			ProxyPort:  pp.proxyPort,
+			Configured: pp.configured,
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ordered := make([]ProxyPortInfo, 0, len(names))
+	for _, name := range names {
+		ordered = append(ordered, snapshot[name])
+	}
+
+	// The hash is computed over a deterministically-ordered encoding so
+	// that two snapshots with identical content always hash identically,
+	// regardless of Go's randomized map iteration order.
+	encoded, _ := json.Marshal(struct {
+		Names []string        `json:"names"`
+		Ports []ProxyPortInfo `json:"ports"`
+	}{Names: names, Ports: ordered})
+
+	sum := sha256.Sum256(encoded)
+	return snapshot, hex.EncodeToString(sum[:])
+}
+
+// WatchProxyPorts blocks until the set of allocated/acked proxy ports
+// differs from the snapshot identified by prevHash, or ctx is done,
+// whichever comes first. It returns the new snapshot and its hash, suitable
+// for being passed back in as prevHash on the next call, mirroring the
+// hash-based long-poll pattern used by other service-mesh control planes to
+// push proxy configuration to subscribers without them having to poll
+// GetProxyPort.
+//
+// An empty prevHash always returns immediately with the current snapshot.
+func (p *Proxy) WatchProxyPorts(ctx context.Context, prevHash string) (map[string]ProxyPortInfo, string, error) {
+	p.proxyPortsMutex.Lock()
+	defer p.proxyPortsMutex.Unlock()
+
+	snapshot, hash := p.snapshotProxyPorts()
+	if prevHash == "" || hash != prevHash {
+		return snapshot, hash, nil
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		// Wake up the waiter below; it will observe ctx.Err() and return.
+		p.proxyPortsCond.Broadcast()
+	})
+	defer stop()
+
+	for hash == prevHash && ctx.Err() == nil {
+		p.proxyPortsCond.Wait()
+		snapshot, hash = p.snapshotProxyPorts()
+	}
+
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+
+	return snapshot, hash, nil
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/cilium/cilium/pkg/time"
+)
+
+// watchProxyPortsTimeout bounds how long a single long-poll request to
+// ServeWatchProxyPorts blocks before returning the (possibly unchanged)
+// current snapshot, so that intermediate proxies/load balancers don't time
+// out the connection first.
+const watchProxyPortsTimeout = 2 * time.Minute
+
+// watchProxyPortsResponse is the JSON body returned by ServeWatchProxyPorts.
+type watchProxyPortsResponse struct {
+	Ports map[string]ProxyPortInfo `json:"ports"`
+	Hash  string                   `json:"hash"`
+}
+
+// ServeWatchProxyPorts exposes WatchProxyPorts as a long-poll REST endpoint,
+// e.g. mounted by the daemon at GET /v1/proxy/ports/watch?hash=<prevHash>.
+// It lets CNI/operator components subscribe to AckProxyPort/ReleaseProxyPort
+// transitions without having to poll GetProxyPort on a timer.
+func (p *Proxy) ServeWatchProxyPorts(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), watchProxyPortsTimeout)
+	defer cancel()
+
+	ports, hash, err := p.WatchProxyPorts(ctx, r.URL.Query().Get("hash"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(watchProxyPortsResponse{Ports: ports, Hash: hash})
+}